@@ -0,0 +1,234 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// newDiagnosticsServer builds the *http.Server that will front config.mux,
+// wiring up TLS termination and, when TLS is enabled, a TokenReview/
+// SubjectAccessReview-backed authn/authz filter chain modeled after the
+// delegated authentication used by k8s.io/apiserver. When no TLS flags are
+// set, the server falls back to the historical plain-HTTP behavior.
+func newDiagnosticsServer(config *Config) (*http.Server, error) {
+	handler := http.Handler(config.mux)
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		klog.Warning("Serving diagnostics endpoint over plain HTTP: no --tls-cert-file/--tls-private-key-file set. " +
+			"This exposes pprof and cluster-internal metrics without authentication; set TLS flags in production.")
+		return &http.Server{Handler: handler}, nil
+	}
+
+	authn, err := newBearerTokenAuthenticator(*config.flags.authenticationKubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("configure authentication: %v", err)
+	}
+
+	authz, err := newSubjectAccessAuthorizer(*config.flags.authorizationKubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("configure authorization: %v", err)
+	}
+
+	handler = withAuthFilter(handler, authn, authz, *config.flags.authorizationAlwaysAllowPaths)
+
+	return &http.Server{Handler: handler, TLSConfig: tlsConfig}, nil
+}
+
+// buildTLSConfig returns nil, nil when no TLS flags are set, preserving the
+// current insecure behavior.
+func buildTLSConfig(config *Config) (*tls.Config, error) {
+	certFile := *config.flags.tlsCertFile
+	keyFile := *config.flags.tlsPrivateKeyFile
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both --tls-cert-file and --tls-private-key-file must be set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate/key: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caFile := *config.flags.clientCAFile; caFile != "" {
+		caBytes, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", caFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// tokenAuthenticator authenticates a bearer token against the apiserver via
+// TokenReview, returning the authenticated username or an error.
+type tokenAuthenticator func(token string) (string, error)
+
+func newBearerTokenAuthenticator(kubeconfig string) (tokenAuthenticator, error) {
+	if kubeconfig == "" {
+		return func(token string) (string, error) {
+			return "", fmt.Errorf("no bearer token authenticator configured (set --authentication-kubeconfig)")
+		}, nil
+	}
+
+	client, err := coreClientForKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(token string) (string, error) {
+		review, err := client.AuthenticationV1().TokenReviews().Create(
+			context.TODO(), &authenticationv1.TokenReview{
+				Spec: authenticationv1.TokenReviewSpec{Token: token},
+			}, metav1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("tokenreview: %v", err)
+		}
+		if !review.Status.Authenticated {
+			return "", fmt.Errorf("token not authenticated: %s", review.Status.Error)
+		}
+		return review.Status.User.Username, nil
+	}, nil
+}
+
+// subjectAuthorizer authorizes an already-authenticated user for a
+// non-resource HTTP path via SubjectAccessReview.
+type subjectAuthorizer func(user, verb, path string) (bool, error)
+
+func newSubjectAccessAuthorizer(kubeconfig string) (subjectAuthorizer, error) {
+	if kubeconfig == "" {
+		return func(user, verb, path string) (bool, error) {
+			return true, nil
+		}, nil
+	}
+
+	client, err := coreClientForKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(user, verb, path string) (bool, error) {
+		review, err := client.AuthorizationV1().SubjectAccessReviews().Create(
+			context.TODO(), &authorizationv1.SubjectAccessReview{
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					User: user,
+					NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+						Path: path,
+						Verb: verb,
+					},
+				},
+			}, metav1.CreateOptions{})
+		if err != nil {
+			return false, fmt.Errorf("subjectaccessreview: %v", err)
+		}
+		return review.Status.Allowed, nil
+	}, nil
+}
+
+func coreClientForKubeconfig(kubeconfig string) (coreclientset.Interface, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig %s: %v", kubeconfig, err)
+	}
+	return coreclientset.NewForConfig(restConfig)
+}
+
+// withAuthFilter authenticates via client certificate (already verified by
+// the TLS handshake) or bearer token, then authorizes the resulting user
+// against the requested path before delegating to next. Paths in
+// alwaysAllowPaths bypass both checks, matching the kube-apiserver
+// convention used for unauthenticated liveness/readiness probes.
+func withAuthFilter(next http.Handler, authn tokenAuthenticator, authz subjectAuthorizer, alwaysAllowPaths []string) http.Handler {
+	allow := make(map[string]bool, len(alwaysAllowPaths))
+	for _, p := range alwaysAllowPaths {
+		allow[p] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if allow[req.URL.Path] {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		user, err := authenticate(req, authn)
+		if err != nil {
+			klog.V(4).InfoS("Diagnostics endpoint authentication failed", "err", err, "path", req.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		allowed, err := authz(user, httpVerbToAuthorizationVerb(req.Method), req.URL.Path)
+		if err != nil || !allowed {
+			klog.V(4).InfoS("Diagnostics endpoint authorization denied", "err", err, "user", user, "path", req.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func authenticate(req *http.Request, authn tokenAuthenticator) (string, error) {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return req.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	header := req.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return "", fmt.Errorf("no client certificate or bearer token presented")
+	}
+
+	return authn(strings.TrimPrefix(header, prefix))
+}
+
+func httpVerbToAuthorizationVerb(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return "get"
+	}
+	return strings.ToLower(method)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1alpha2"
+	"k8s.io/dynamic-resource-allocation/controller"
+)
+
+// instrumentedDriver wraps the controller.Driver returned by NewDriver so
+// that every Allocate and Deallocate decision emits a corresponding Event,
+// audit-log line and metric, without requiring changes to the driver's own
+// allocation logic. UnsuitableNodes emits Events and metrics only when it
+// fails or a claim reports an error, and writes no audit-log line.
+type instrumentedDriver struct {
+	controller.Driver
+	config *Config
+}
+
+// newInstrumentedDriver decorates driver with the event/audit-log/metrics
+// subsystem described by config. config.recorder and config.auditLog are
+// both optional (nil-safe); when neither is configured the wrapper only
+// records metrics.
+func newInstrumentedDriver(driver controller.Driver, config *Config) controller.Driver {
+	return &instrumentedDriver{Driver: driver, config: config}
+}
+
+func (d *instrumentedDriver) Allocate(ctx context.Context, claim *resourceapi.ResourceClaim, claimParameters interface{}, class *resourceapi.ResourceClass, classParameters interface{}, selectedNode string) (*resourceapi.AllocationResult, error) {
+	start := time.Now()
+	result, err := d.Driver.Allocate(ctx, claim, claimParameters, class, classParameters, selectedNode)
+	latency := time.Since(start)
+
+	reason, eventType, metricResult := ReasonAllocationSucceeded, corev1.EventTypeNormal, "success"
+	if err != nil {
+		reason, eventType, metricResult = ReasonAllocationFailed, corev1.EventTypeWarning, "failure"
+	}
+
+	RecordAllocationMetric(metricResult, latency)
+
+	if d.config.recorder != nil {
+		d.config.recorder.Eventf(claim, eventType, reason, "Allocation on node %q: %s", selectedNode, allocationOutcome(err))
+	}
+
+	if d.config.auditLog != nil {
+		d.config.auditLog.Log(AuditEntry{
+			Timestamp:      time.Now(),
+			ClaimUID:       string(claim.UID),
+			Node:           selectedNode,
+			ChosenGPUUUIDs: chosenGPUUUIDs(result),
+			Result:         metricResult,
+			LatencySeconds: latency.Seconds(),
+		})
+	}
+
+	return result, err
+}
+
+func (d *instrumentedDriver) Deallocate(ctx context.Context, claim *resourceapi.ResourceClaim) error {
+	start := time.Now()
+	err := d.Driver.Deallocate(ctx, claim)
+	latency := time.Since(start)
+
+	reason, eventType, metricResult := ReasonDeallocationSucceeded, corev1.EventTypeNormal, "success"
+	if err != nil {
+		reason, eventType, metricResult = ReasonAllocationFailed, corev1.EventTypeWarning, "failure"
+	}
+
+	RecordAllocationMetric("deallocate_"+metricResult, latency)
+
+	if d.config.recorder != nil {
+		d.config.recorder.Eventf(claim, eventType, reason, "Deallocation: %s", allocationOutcome(err))
+	}
+
+	if d.config.auditLog != nil {
+		d.config.auditLog.Log(AuditEntry{
+			Timestamp:      time.Now(),
+			ClaimUID:       string(claim.UID),
+			Result:         metricResult,
+			LatencySeconds: latency.Seconds(),
+		})
+	}
+
+	return err
+}
+
+func (d *instrumentedDriver) UnsuitableNodes(ctx context.Context, pod *corev1.Pod, claims []*controller.ClaimAllocation, potentialNodes []string) error {
+	err := d.Driver.UnsuitableNodes(ctx, pod, claims, potentialNodes)
+
+	if err != nil && d.config.recorder != nil {
+		d.config.recorder.Eventf(pod, corev1.EventTypeWarning, ReasonInsufficientGPUs, "UnsuitableNodes: %v", err)
+	}
+
+	for _, claimAllocation := range claims {
+		if claimAllocation.Error == nil {
+			continue
+		}
+		RecordAllocationMetric("unsuitable_node", 0)
+		if d.config.recorder != nil && claimAllocation.Claim != nil {
+			d.config.recorder.Eventf(claimAllocation.Claim, corev1.EventTypeWarning, ReasonInsufficientGPUs, "%v", claimAllocation.Error)
+		}
+	}
+
+	return err
+}
+
+func allocationOutcome(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "succeeded"
+}
+
+// chosenGPUUUIDs extracts the GPU UUIDs a successful allocation selected
+// from its opaque ResourceHandle data, for inclusion in the audit log.
+func chosenGPUUUIDs(result *resourceapi.AllocationResult) []string {
+	if result == nil {
+		return nil
+	}
+
+	var uuids []string
+	for _, handle := range result.ResourceHandles {
+		if handle.Data == "" {
+			continue
+		}
+		uuids = append(uuids, handle.Data)
+	}
+	return uuids
+}
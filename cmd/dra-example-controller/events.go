@@ -0,0 +1,144 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	coreclientset "k8s.io/client-go/kubernetes"
+)
+
+// Reasons used when emitting Events for allocation/deallocation decisions,
+// following the Kubernetes convention of a short CamelCase string.
+const (
+	ReasonAllocationSucceeded   = "AllocationSucceeded"
+	ReasonAllocationFailed      = "AllocationFailed"
+	ReasonDeallocationSucceeded = "DeallocationSucceeded"
+	ReasonInsufficientGPUs      = "InsufficientGPUs"
+)
+
+var (
+	allocationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dra_allocations_total",
+			Help: "Total number of ResourceClaim allocation attempts, by result.",
+		},
+		[]string{"result"},
+	)
+	allocationDurationSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "dra_allocation_duration_seconds",
+			Help:    "Latency of ResourceClaim allocation decisions.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(allocationsTotal, allocationDurationSeconds)
+}
+
+// NewEventRecorder builds a record.EventRecorder that emits Events against
+// the apiserver on behalf of the dra-example-controller component. The
+// driver wrapper built by newInstrumentedDriver uses it to surface Allocate/Deallocate/UnsuitableNodes outcomes on the
+// ResourceClaim and Pod objects involved, so operators can see DRA behavior
+// with `kubectl describe` during an incident instead of only in logs.
+func NewEventRecorder(client coreclientset.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	broadcaster.StartStructuredLogging(0)
+
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "dra-example-controller"})
+}
+
+// RecordAllocationMetric updates the dra_allocations_total and
+// dra_allocation_duration_seconds metrics for a single allocation decision.
+func RecordAllocationMetric(result string, duration time.Duration) {
+	allocationsTotal.WithLabelValues(result).Inc()
+	allocationDurationSeconds.Observe(duration.Seconds())
+}
+
+// AuditEntry is one line of the JSON audit log: one entry per allocation or
+// deallocation decision made by the controller.
+type AuditEntry struct {
+	Timestamp       time.Time         `json:"timestamp"`
+	ClaimUID        string            `json:"claimUID"`
+	Node            string            `json:"node"`
+	RequestedParams map[string]string `json:"requestedParams,omitempty"`
+	ChosenGPUUUIDs  []string          `json:"chosenGPUUUIDs,omitempty"`
+	Result          string            `json:"result"`
+	LatencySeconds  float64           `json:"latencySeconds"`
+}
+
+// AuditLogger writes one JSON AuditEntry per line to a rotated file,
+// following the same maxsize/maxbackups rotation model as the Kubernetes
+// apiserver audit log backend.
+type AuditLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	out *lumberjack.Logger
+}
+
+// NewAuditLogger opens (creating if necessary) the audit log file at path,
+// rotating it once it exceeds maxSizeMB megabytes and retaining at most
+// maxBackups rotated files.
+func NewAuditLogger(path string, maxSizeMB, maxBackups int) (*AuditLogger, error) {
+	out := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}
+
+	// Fail fast if the path isn't writable rather than discovering it on
+	// the first allocation decision. An empty write makes lumberjack open
+	// (or create) the file now.
+	if _, err := out.Write(nil); err != nil {
+		return nil, fmt.Errorf("open audit log path %s: %v", path, err)
+	}
+
+	return &AuditLogger{
+		enc: json.NewEncoder(out),
+		out: out,
+	}, nil
+}
+
+// Log appends entry as a single JSON line to the audit log.
+func (a *AuditLogger) Log(entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.enc.Encode(entry); err != nil {
+		klog.ErrorS(err, "Failed to write audit log entry", "claimUID", entry.ClaimUID)
+	}
+}
+
+// Close flushes and closes the underlying audit log file.
+func (a *AuditLogger) Close() error {
+	return a.out.Close()
+}
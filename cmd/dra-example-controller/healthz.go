@@ -0,0 +1,218 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+
+	nascrd "github.com/kubernetes-sigs/dra-example-driver/api/example.com/resource/gpu/nas/v1alpha1"
+	nasclient "github.com/kubernetes-sigs/dra-example-driver/api/example.com/resource/gpu/nas/v1alpha1/client"
+)
+
+// healthChecker is a single named liveness/readiness probe, modeled after
+// k8s.io/apiserver/pkg/server/healthz.HealthChecker.
+type healthChecker interface {
+	Name() string
+	Check(req *http.Request) error
+}
+
+type checkerFunc struct {
+	name string
+	fn   func(req *http.Request) error
+}
+
+func (c *checkerFunc) Name() string                  { return c.name }
+func (c *checkerFunc) Check(req *http.Request) error { return c.fn(req) }
+
+// namedCheck builds a healthChecker out of a plain function.
+func namedCheck(name string, fn func(req *http.Request) error) healthChecker {
+	return &checkerFunc{name: name, fn: fn}
+}
+
+// installHealthz installs a liveness endpoint that always reports ok once
+// the process is up; it deliberately does not depend on cluster state so a
+// transient apiserver outage does not get the pod killed.
+func installHealthz(config *Config) {
+	if *config.flags.healthzPath == "" {
+		return
+	}
+
+	actualPath := path.Join("/", *config.flags.healthzPath)
+	klog.InfoS("Starting healthz", "path", actualPath)
+	installHandler(config, actualPath, []healthChecker{
+		namedCheck("ping", func(req *http.Request) error { return nil }),
+	})
+}
+
+// installReadyz installs a readiness endpoint gated on (a) the shared
+// informer caches having synced and (b) the apiserver being reachable.
+func installReadyz(config *Config) {
+	if *config.flags.readyzPath == "" {
+		return
+	}
+
+	// A closed channel makes WaitForCacheSync report the caches' current
+	// HasSynced state immediately instead of blocking until they sync.
+	closedCh := make(chan struct{})
+	close(closedCh)
+
+	checks := []healthChecker{
+		namedCheck("informer-sync", func(req *http.Request) error {
+			for t, synced := range config.informerFactory.WaitForCacheSync(closedCh) {
+				if !synced {
+					return fmt.Errorf("informer cache for %v not synced", t)
+				}
+			}
+			return nil
+		}),
+		namedCheck("apiserver-reachable", func(req *http.Request) error {
+			_, err := discovery.NewDiscoveryClientForConfigOrDie(config.csconfig).ServerVersion()
+			return err
+		}),
+	}
+
+	// The NAS CRD is only owned by the node-local kubelet-plugin instance of
+	// this binary, so this check only makes sense when --node-name is set.
+	if *config.flags.nodeName != "" {
+		checks = append(checks, namedCheck("nas-crd-get-or-create", func(req *http.Request) error {
+			return probeNASCRDGetOrCreate(config)
+		}))
+	}
+
+	actualPath := path.Join("/", *config.flags.readyzPath)
+	klog.InfoS("Starting readyz", "path", actualPath)
+	installHandler(config, actualPath, checks)
+}
+
+// probeNASCRDGetOrCreate verifies that the NodeAllocationState CRD for
+// config.flags.nodeName can be fetched or, if absent, created, mirroring the
+// get-or-create call cmd/set-nas-status makes on the kubelet-plugin's
+// behalf. It is only meaningful when this binary is colocated with a node
+// (i.e. run as the kubelet-plugin), which is why installReadyz only wires it
+// up when --node-name is set.
+func probeNASCRDGetOrCreate(config *Config) error {
+	nodeName := *config.flags.nodeName
+
+	node, err := config.clientset.core.CoreV1().Nodes().Get(config.ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get node object: %v", err)
+	}
+
+	crdconfig := &nascrd.NodeAllocationStateConfig{
+		Name:      nodeName,
+		Namespace: config.namespace,
+		Owner: &metav1.OwnerReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       nodeName,
+			UID:        node.UID,
+		},
+	}
+
+	client := nasclient.New(nascrd.NewNodeAllocationState(crdconfig), config.clientset.example.NasV1alpha1())
+	if err := client.GetOrCreate(); err != nil {
+		return fmt.Errorf("get or create NodeAllocationState: %v", err)
+	}
+	return nil
+}
+
+// installHandler serves "ok" when every checker passes, and a 500 body
+// listing the checks that failed otherwise. Appending "?verbose" echoes the
+// result of every check, matching the kube-apiserver healthz convention.
+func installHandler(config *Config, actualPath string, checks []healthChecker) {
+	config.mux.HandleFunc(actualPath, func(w http.ResponseWriter, req *http.Request) {
+		_, verbose := req.URL.Query()["verbose"]
+
+		var lines []string
+		ok := true
+		for _, check := range checks {
+			if err := check.Check(req); err != nil {
+				ok = false
+				lines = append(lines, fmt.Sprintf("[-]%s failed: %v", check.Name(), err))
+			} else if verbose {
+				lines = append(lines, fmt.Sprintf("[+]%s ok", check.Name()))
+			}
+		}
+
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if len(lines) == 0 {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	})
+}
+
+// effectiveConfig is the JSON shape returned by the /configz endpoint.
+type effectiveConfig struct {
+	KubeconfigSource string   `json:"kubeconfigSource"`
+	Workers          int      `json:"workers"`
+	KubeAPIQPS       float32  `json:"kubeAPIQPS"`
+	KubeAPIBurst     int      `json:"kubeAPIBurst"`
+	HTTPEndpoint     string   `json:"httpEndpoint"`
+	MetricsPath      string   `json:"metricsPath"`
+	ProfilePath      string   `json:"profilePath"`
+	LeaderElect      bool     `json:"leaderElect"`
+	FeatureGates     []string `json:"featureGates"`
+}
+
+// installConfigz installs an endpoint returning the effective driver
+// configuration, so operators can inspect what a running pod is actually
+// using without guessing at flag precedence between CLI args and env vars.
+func installConfigz(config *Config) {
+	if *config.flags.configzPath == "" {
+		return
+	}
+
+	kubeconfigSource := "in-cluster"
+	if *config.flags.kubeconfig != "" {
+		kubeconfigSource = *config.flags.kubeconfig
+	}
+
+	actualPath := path.Join("/", *config.flags.configzPath)
+	klog.InfoS("Starting configz", "path", actualPath)
+	config.mux.HandleFunc(actualPath, func(w http.ResponseWriter, req *http.Request) {
+		effective := effectiveConfig{
+			KubeconfigSource: kubeconfigSource,
+			Workers:          *config.flags.workers,
+			KubeAPIQPS:       *config.flags.kubeAPIQPS,
+			KubeAPIBurst:     *config.flags.kubeAPIBurst,
+			HTTPEndpoint:     *config.flags.httpEndpoint,
+			MetricsPath:      *config.flags.metricsPath,
+			ProfilePath:      *config.flags.profilePath,
+			LeaderElect:      *config.flags.leaderElect,
+			FeatureGates:     config.featureGate.KnownFeatures(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(effective); err != nil {
+			klog.ErrorS(err, "Failed to encode configz response")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
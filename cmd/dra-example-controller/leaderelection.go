@@ -0,0 +1,104 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// leaderElectionMasterStatus mirrors the gauge of the same name exposed by
+// kube-controller-manager: 1 if this replica currently holds the lease, 0
+// otherwise.
+var leaderElectionMasterStatus = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "leader_election_master_status",
+		Help: "Gauge of if the reporting system is master of the relevant lease, 0 indicates backup, 1 indicates master. 'name' is the string used to identify the lease. Please make sure to group by name.",
+	},
+	[]string{"name"},
+)
+
+func init() {
+	prometheus.MustRegister(leaderElectionMasterStatus)
+}
+
+// RunWithLeaderElection blocks until this replica acquires the
+// dra-example-controller Lease in config.namespace, then invokes run with a
+// context that is cancelled as soon as leadership is lost. It never returns
+// while holding leadership; on losing the lease it flushes logs and exits so
+// that the Deployment restarts the pod and a fresh leader election occurs.
+func RunWithLeaderElection(config *Config, run func(ctx context.Context)) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("get hostname for leader election identity: %v", err)
+	}
+	identity = identity + "_" + uuid.New().String()
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		config.namespace,
+		*config.flags.leaderElectResourceName,
+		config.clientset.core.CoreV1(),
+		config.clientset.core.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("create resource lock: %v", err)
+	}
+
+	leName := *config.flags.leaderElectResourceName
+	leaderElectionMasterStatus.WithLabelValues(leName).Set(0)
+
+	leaderelection.RunOrDie(config.ctx, leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: *config.flags.leaderElectLeaseDuration,
+		RenewDeadline: *config.flags.leaderElectRenewDeadline,
+		RetryPeriod:   *config.flags.leaderElectRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.InfoS("Acquired leadership, starting controller", "identity", identity)
+				leaderElectionMasterStatus.WithLabelValues(leName).Set(1)
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				leaderElectionMasterStatus.WithLabelValues(leName).Set(0)
+				klog.InfoS("Lost leadership, exiting", "identity", identity)
+				klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+			},
+			OnNewLeader: func(newLeader string) {
+				if newLeader == identity {
+					return
+				}
+				klog.InfoS("New leader elected", "leader", newLeader)
+			},
+		},
+		ReleaseOnCancel: true,
+		Name:            leName,
+	})
+
+	return nil
+}
@@ -24,7 +24,9 @@ import (
 	"net/http/pprof"
 	"os"
 	"path"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -37,6 +39,7 @@ import (
 	coreclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/component-base/cli"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/featuregate"
@@ -60,10 +63,32 @@ type Flags struct {
 	kubeAPIQPS   *float32
 	kubeAPIBurst *int
 	workers      *int
-
-	httpEndpoint *string
-	metricsPath  *string
-	profilePath  *string
+	nodeName     *string
+
+	httpEndpoint        *string
+	metricsPath         *string
+	profilePath         *string
+	healthzPath         *string
+	readyzPath          *string
+	configzPath         *string
+	contentionProfiling *bool
+
+	tlsCertFile                   *string
+	tlsPrivateKeyFile             *string
+	clientCAFile                  *string
+	authenticationKubeconfig      *string
+	authorizationKubeconfig       *string
+	authorizationAlwaysAllowPaths *[]string
+
+	auditLogPath       *string
+	auditLogMaxSize    *int
+	auditLogMaxBackups *int
+
+	leaderElect              *bool
+	leaderElectLeaseDuration *time.Duration
+	leaderElectRenewDeadline *time.Duration
+	leaderElectRetryPeriod   *time.Duration
+	leaderElectResourceName  *string
 }
 
 type Clientset struct {
@@ -72,12 +97,16 @@ type Clientset struct {
 }
 
 type Config struct {
-	namespace string
-	flags     *Flags
-	csconfig  *rest.Config
-	clientset *Clientset
-	ctx       context.Context
-	mux       *http.ServeMux
+	namespace       string
+	flags           *Flags
+	csconfig        *rest.Config
+	clientset       *Clientset
+	ctx             context.Context
+	mux             *http.ServeMux
+	informerFactory informers.SharedInformerFactory
+	recorder        record.EventRecorder
+	auditLog        *AuditLogger
+	featureGate     featuregate.FeatureGate
 }
 
 func main() {
@@ -152,6 +181,17 @@ func NewCommand() *cobra.Command {
 				exampleclient,
 			},
 		}
+		config.informerFactory = informers.NewSharedInformerFactory(config.clientset.core, 0 /* resync period */)
+		config.recorder = NewEventRecorder(config.clientset.core)
+		config.featureGate = featureGate
+
+		if *flags.auditLogPath != "" {
+			config.auditLog, err = NewAuditLogger(*flags.auditLogPath, *flags.auditLogMaxSize, *flags.auditLogMaxBackups)
+			if err != nil {
+				return fmt.Errorf("create audit log: %v", err)
+			}
+			defer config.auditLog.Close()
+		}
 
 		if *flags.httpEndpoint != "" {
 			err = SetupHTTPEndpoint(config)
@@ -184,12 +224,37 @@ func AddFlags(cmd *cobra.Command, logsconfig *logsapi.LoggingConfiguration, feat
 	flags.kubeAPIQPS = fs.Float32("kube-api-qps", 5, "QPS to use while communicating with the kubernetes apiserver.")
 	flags.kubeAPIBurst = fs.Int("kube-api-burst", 10, "Burst to use while communicating with the kubernetes apiserver.")
 	flags.workers = fs.Int("workers", 10, "Concurrency to process multiple claims")
+	flags.nodeName = fs.String("node-name", os.Getenv("NODE_NAME"), "The name of the Node this process is colocated with, if any. Only used to back the readyz NAS CRD checker when this binary is run as the kubelet-plugin; left empty when running as the cluster-wide controller.")
+
+	fs = sharedFlagSets.FlagSet("leader election")
+	flags.leaderElect = fs.Bool("leader-elect", false, "Start a leader election client and gain leadership before executing the main loop. Enable this when running replicated instances of the controller for high availability.")
+	flags.leaderElectLeaseDuration = fs.Duration("leader-elect-lease-duration", 15*time.Second, "The duration that non-leader candidates will wait after observing a leadership renewal until attempting to acquire leadership of a led but unrenewed leader slot. This is effectively the maximum duration that a leader can be stopped before it is replaced by another candidate.")
+	flags.leaderElectRenewDeadline = fs.Duration("leader-elect-renew-deadline", 10*time.Second, "The interval between attempts by the acting master to renew a leadership slot before it stops leading. This must be less than the lease duration.")
+	flags.leaderElectRetryPeriod = fs.Duration("leader-elect-retry-period", 2*time.Second, "The duration the clients should wait between attempting acquisition and renewal of a leadership.")
+	flags.leaderElectResourceName = fs.String("leader-elect-resource-name", "dra-example-controller", "The name of resource object (a Lease) that is used for locking during leader election.")
 
 	fs = sharedFlagSets.FlagSet("http server")
 	flags.httpEndpoint = fs.String("http-endpoint", "",
 		"The TCP network address where the HTTP server for diagnostics, including pprof and metrics will listen (example: `:8080`). The default is the empty string, which means the server is disabled.")
 	flags.metricsPath = fs.String("metrics-path", "/metrics", "The HTTP path where Prometheus metrics will be exposed, disabled if empty.")
 	flags.profilePath = fs.String("pprof-path", "", "The HTTP path where pprof profiling will be available, disabled if empty.")
+	flags.contentionProfiling = fs.Bool("contention-profiling", false, "Enable lock contention profiling. Only takes effect when pprof-path is also set.")
+	flags.healthzPath = fs.String("healthz-path", "/healthz", "The HTTP path where the liveness endpoint will be exposed, disabled if empty.")
+	flags.readyzPath = fs.String("readyz-path", "/readyz", "The HTTP path where the readiness endpoint will be exposed, disabled if empty.")
+	flags.configzPath = fs.String("configz-path", "/configz", "The HTTP path where the effective driver configuration will be exposed as JSON, disabled if empty.")
+
+	fs = sharedFlagSets.FlagSet("http server security")
+	flags.tlsCertFile = fs.String("tls-cert-file", "", "File containing the default x509 certificate for HTTPS. If unset and tls-private-key-file is set, the HTTP server is disabled and the process exits with an error.")
+	flags.tlsPrivateKeyFile = fs.String("tls-private-key-file", "", "File containing the default x509 private key matching tls-cert-file.")
+	flags.clientCAFile = fs.String("client-ca-file", "", "If set, any request presenting a client certificate signed by one of the authorities in this bundle is authenticated with an identity corresponding to the CommonName of the client certificate.")
+	flags.authenticationKubeconfig = fs.String("authentication-kubeconfig", "", "kubeconfig file pointing at the 'core' kubernetes server with enough rights to create tokenreviews.authentication.k8s.io. Bearer tokens presented to the diagnostics endpoint are authenticated against this apiserver. This is optional; if empty, all bearer token requests are rejected once TLS is enabled.")
+	flags.authorizationKubeconfig = fs.String("authorization-kubeconfig", "", "kubeconfig file pointing at the 'core' kubernetes server with enough rights to create subjectaccessreviews.authorization.k8s.io. This is used to determine if a user, a group or a service account is authorized to call the diagnostics endpoint. This is optional; if empty, all authenticated requests are allowed once TLS is enabled.")
+	flags.authorizationAlwaysAllowPaths = fs.StringSlice("authorization-always-allow-paths", []string{"/healthz", "/readyz"}, "A list of HTTP paths to skip during authorization, i.e. these are always allowed regardless of the RBAC setup of the caller.")
+
+	fs = sharedFlagSets.FlagSet("audit")
+	flags.auditLogPath = fs.String("audit-log-path", "", "If set, write a JSON audit log line for every allocation/deallocation decision to this path. Disabled if empty.")
+	flags.auditLogMaxSize = fs.Int("audit-log-maxsize", 100, "The maximum size in megabytes of the audit log file before it gets rotated.")
+	flags.auditLogMaxBackups = fs.Int("audit-log-maxbackups", 5, "The maximum number of rotated audit log files to retain.")
 
 	fs = sharedFlagSets.FlagSet("other")
 	featureGate.AddFlag(fs)
@@ -263,17 +328,36 @@ func SetupHTTPEndpoint(config *Config) error {
 		config.mux.HandleFunc(path.Join("/", *config.flags.profilePath, "profile"), pprof.Profile)
 		config.mux.HandleFunc(path.Join("/", *config.flags.profilePath, "symbol"), pprof.Symbol)
 		config.mux.HandleFunc(path.Join("/", *config.flags.profilePath, "trace"), pprof.Trace)
+
+		if *config.flags.contentionProfiling {
+			runtime.SetBlockProfileRate(1)
+			runtime.SetMutexProfileFraction(1)
+		}
 	}
 
+	installHealthz(config)
+	installReadyz(config)
+	installConfigz(config)
+
 	listener, err := net.Listen("tcp", *config.flags.httpEndpoint)
 	if err != nil {
 		return fmt.Errorf("listen on HTTP endpoint: %v", err)
 	}
 
+	server, err := newDiagnosticsServer(config)
+	if err != nil {
+		return fmt.Errorf("configure diagnostics server security: %v", err)
+	}
+
 	go func() {
-		klog.InfoS("Starting HTTP server", "endpoint", *config.flags.httpEndpoint)
-		err := http.Serve(listener, config.mux)
-		if err != nil {
+		klog.InfoS("Starting HTTP server", "endpoint", *config.flags.httpEndpoint, "tls", server.TLSConfig != nil)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			klog.ErrorS(err, "HTTP server failed")
 			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 		}
@@ -283,10 +367,17 @@ func SetupHTTPEndpoint(config *Config) error {
 }
 
 func StartController(config *Config) error {
-	driver := NewDriver(config)
-	informerFactory := informers.NewSharedInformerFactory(config.clientset.core, 0 /* resync period */)
-	ctrl := controller.New(config.ctx, DriverAPIGroup, driver, config.clientset.core, informerFactory)
-	informerFactory.Start(config.ctx.Done())
-	ctrl.Run(*config.flags.workers)
-	return nil
+	runController := func(ctx context.Context) {
+		driver := newInstrumentedDriver(NewDriver(config), config)
+		ctrl := controller.New(ctx, DriverAPIGroup, driver, config.clientset.core, config.informerFactory)
+		config.informerFactory.Start(ctx.Done())
+		ctrl.Run(*config.flags.workers)
+	}
+
+	if !*config.flags.leaderElect {
+		runController(config.ctx)
+		return nil
+	}
+
+	return RunWithLeaderElection(config, runController)
 }
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -30,6 +31,7 @@ import (
 	coreclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/term"
@@ -43,12 +45,27 @@ import (
 type Flags struct {
 	kubeconfig *string
 	status     *string
+
+	oneshot           *bool
+	nodeName          *string
+	pluginSocketPath  *string
+	healthCheckPeriod *time.Duration
+
+	httpEndpoint *string
+	metricsPath  *string
+}
+
+type Clientset struct {
+	core    coreclientset.Interface
+	example exampleclientset.Interface
 }
 
 type Config struct {
 	flags         *Flags
 	nascrd        *nascrd.NodeAllocationState
 	exampleclient exampleclientset.Interface
+	clientset     *Clientset
+	recorder      record.EventRecorder
 }
 
 func main() {
@@ -105,7 +122,7 @@ func NewCommand() *cobra.Command {
 			return fmt.Errorf("create example.com client: %v", err)
 		}
 
-		nodeName := os.Getenv("NODE_NAME")
+		nodeName := *flags.nodeName
 		podNamespace := os.Getenv("POD_NAMESPACE")
 
 		node, err := coreclient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
@@ -129,9 +146,24 @@ func NewCommand() *cobra.Command {
 			flags:         flags,
 			nascrd:        nascrd,
 			exampleclient: exampleclient,
+			clientset: &Clientset{
+				core:    coreclient,
+				example: exampleclient,
+			},
+			recorder: NewEventRecorder(coreclient),
+		}
+
+		if *flags.oneshot {
+			return SetStatus(config)
 		}
 
-		return SetStatus(config)
+		if *flags.httpEndpoint != "" {
+			if err := SetupMetricsEndpoint(config); err != nil {
+				return fmt.Errorf("create metrics endpoint: %v", err)
+			}
+		}
+
+		return RunReconciler(config)
 	}
 
 	return cmd
@@ -143,7 +175,17 @@ func AddFlags(cmd *cobra.Command) *Flags {
 
 	fs := sharedFlagSets.FlagSet("Kubernetes client")
 	flags.kubeconfig = fs.String("kubeconfig", "", "Absolute path to the kube.config file. Either this or KUBECONFIG need to be set if the driver is being run out of cluster.")
-	flags.status = fs.String("status", "", "The status to set [Ready | NotReady].")
+	flags.status = fs.String("status", "", "The status to set [Ready | NotReady]. Only used with --oneshot.")
+	flags.nodeName = fs.String("node-name", os.Getenv("NODE_NAME"), "The name of the Node this process is running on. Defaults to the NODE_NAME environment variable.")
+
+	fs = sharedFlagSets.FlagSet("reconciler")
+	flags.oneshot = fs.Bool("oneshot", true, "Set the NodeAllocationState status once and exit, preserving the original set-nas-status behavior. Set to false to run as a persistent reconciler that continuously watches node and kubelet-plugin health.")
+	flags.pluginSocketPath = fs.String("plugin-socket-path", "/var/lib/kubelet/plugins/gpu.example.com/plugin.sock", "Path to the kubelet-plugin's registration socket, dialed to determine plugin reachability.")
+	flags.healthCheckPeriod = fs.Duration("health-check-period", 30*time.Second, "How often the reconciler re-probes node and plugin health and reconciles NodeAllocationState.Status. Only used when --oneshot=false.")
+
+	fs = sharedFlagSets.FlagSet("http server")
+	flags.httpEndpoint = fs.String("http-endpoint", "", "The TCP network address where the HTTP server for metrics will listen (example: `:8080`). The default is the empty string, which means the server is disabled. Only used when --oneshot=false.")
+	flags.metricsPath = fs.String("metrics-path", "/metrics", "The HTTP path where Prometheus metrics will be exposed, disabled if empty.")
 
 	fs = cmd.PersistentFlags()
 	for _, f := range sharedFlagSets.FlagSets {
@@ -157,6 +199,10 @@ func AddFlags(cmd *cobra.Command) *Flags {
 }
 
 func ValidateFlags(f *Flags) error {
+	if !*f.oneshot {
+		return nil
+	}
+
 	switch strings.ToLower(*f.status) {
 	case strings.ToLower(nascrd.NodeAllocationStateStatusReady):
 		*f.status = nascrd.NodeAllocationStateStatusReady
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	nasStatusTransitionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nas_status_transitions_total",
+			Help: "Total number of NodeAllocationState.Status transitions made by the reconciler, by the status transitioned to.",
+		},
+		[]string{"status"},
+	)
+	nasReconcileErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nas_reconcile_errors_total",
+			Help: "Total number of errors encountered probing health or reconciling NodeAllocationState.Status.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(nasStatusTransitionsTotal, nasReconcileErrorsTotal)
+}
+
+// SetupMetricsEndpoint starts an HTTP server exposing the reconciler loop's
+// Prometheus metrics, mirroring how dra-example-controller exposes its own
+// diagnostics endpoint.
+func SetupMetricsEndpoint(config *Config) error {
+	mux := http.NewServeMux()
+
+	actualPath := path.Join("/", *config.flags.metricsPath)
+	klog.InfoS("Starting metrics", "path", actualPath)
+	mux.Handle(actualPath, promhttp.Handler())
+
+	listener, err := net.Listen("tcp", *config.flags.httpEndpoint)
+	if err != nil {
+		return fmt.Errorf("listen on HTTP endpoint: %v", err)
+	}
+
+	go func() {
+		klog.InfoS("Starting HTTP server", "endpoint", *config.flags.httpEndpoint)
+		if err := http.Serve(listener, mux); err != nil {
+			klog.ErrorS(err, "HTTP server failed")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	nascrd "github.com/kubernetes-sigs/dra-example-driver/api/example.com/resource/gpu/nas/v1alpha1"
+	nasclient "github.com/kubernetes-sigs/dra-example-driver/api/example.com/resource/gpu/nas/v1alpha1/client"
+)
+
+// NewEventRecorder builds a record.EventRecorder that emits Events against
+// the Node object on status transitions, mirroring how other node-local
+// agents (e.g. kubelet itself) surface health changes.
+func NewEventRecorder(client coreclientset.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	broadcaster.StartStructuredLogging(0)
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "set-nas-status"})
+}
+
+// RunReconciler continuously reconciles NodeAllocationState.Status for as
+// long as the process runs, re-probing health every
+// config.flags.healthCheckPeriod; it never returns. It
+// replaces the original one-shot `set-nas-status --status=...` invocation
+// for deployments that want the CRD to react to runtime failures instead of
+// only reflecting the state at pod startup.
+func RunReconciler(config *Config) error {
+	client := nasclient.New(config.nascrd, config.exampleclient.NasV1alpha1())
+
+	ticker := time.NewTicker(*config.flags.healthCheckPeriod)
+	defer ticker.Stop()
+
+	lastStatus := ""
+	for {
+		status, err := probeStatus(config)
+		if err != nil {
+			nasReconcileErrorsTotal.Inc()
+			klog.ErrorS(err, "Health probe reported a failure, reconciling NodeAllocationState.Status as NotReady", "status", status)
+		}
+
+		if err := reconcileStatus(config, client, status); err != nil {
+			nasReconcileErrorsTotal.Inc()
+			klog.ErrorS(err, "Failed to reconcile NodeAllocationState.Status", "status", status)
+		} else if status != lastStatus {
+			nasStatusTransitionsTotal.WithLabelValues(status).Inc()
+			recordStatusTransitionEvent(config, lastStatus, status)
+			lastStatus = status
+		}
+
+		<-ticker.C
+	}
+}
+
+// reconcileStatus applies status to the NodeAllocationState CRD, retrying on
+// update conflicts the same way the original one-shot SetStatus did.
+func reconcileStatus(config *Config, client *nasclient.Client, status string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := client.GetOrCreate(); err != nil {
+			return err
+		}
+		return client.UpdateStatus(status)
+	})
+}
+
+// probeStatus runs the health probes in order (node condition, plugin
+// socket, plugin HealthCheck RPC) and stops at the first one that fails: any
+// failing probe forces NotReady. That probe's error is also returned so callers can
+// count and log it; RunReconciler still reconciles the derived NotReady
+// status even when an error is returned.
+func probeStatus(config *Config) (string, error) {
+	if err := probeNodeCondition(config); err != nil {
+		klog.V(2).InfoS("Node condition probe failed", "err", err)
+		return nascrd.NodeAllocationStateStatusNotReady, err
+	}
+
+	if err := probePluginSocket(*config.flags.pluginSocketPath); err != nil {
+		klog.V(2).InfoS("Plugin socket probe failed", "err", err)
+		return nascrd.NodeAllocationStateStatusNotReady, err
+	}
+
+	if err := probePluginHealthCheck(*config.flags.pluginSocketPath); err != nil {
+		klog.V(2).InfoS("Plugin HealthCheck RPC probe failed", "err", err)
+		return nascrd.NodeAllocationStateStatusNotReady, err
+	}
+
+	return nascrd.NodeAllocationStateStatusReady, nil
+}
+
+// probeNodeCondition transitions the reconciled status to NotReady when the
+// Node is cordoned or reports Ready=False.
+func probeNodeCondition(config *Config) error {
+	node, err := config.clientset.core.CoreV1().Nodes().Get(context.TODO(), *config.flags.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get node: %v", err)
+	}
+
+	if node.Spec.Unschedulable {
+		return fmt.Errorf("node is cordoned")
+	}
+
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status != corev1.ConditionTrue {
+			return fmt.Errorf("node reports Ready=%s", cond.Status)
+		}
+	}
+
+	return nil
+}
+
+// probePluginSocket checks that the kubelet-plugin's registration socket is
+// at least accepting connections.
+func probePluginSocket(socketPath string) error {
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("dial plugin socket %s: %v", socketPath, err)
+	}
+	return conn.Close()
+}
+
+// probePluginHealthCheck performs a standard gRPC health check against the
+// kubelet-plugin over its registration socket, so a hung process (rather
+// than just a down one) is also detected.
+func probePluginHealthCheck(socketPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, "unix://"+socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("dial plugin gRPC endpoint: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return fmt.Errorf("HealthCheck RPC: %v", err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("plugin reports status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func recordStatusTransitionEvent(config *Config, from, to string) {
+	node := &corev1.ObjectReference{
+		Kind: "Node",
+		Name: *config.flags.nodeName,
+	}
+
+	eventType := corev1.EventTypeNormal
+	if to == nascrd.NodeAllocationStateStatusNotReady {
+		eventType = corev1.EventTypeWarning
+	}
+
+	config.recorder.Eventf(node, eventType, "NodeAllocationStateStatusChanged",
+		"NodeAllocationState status transitioned from %q to %q", from, to)
+}